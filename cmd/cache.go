@@ -0,0 +1,178 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+
+	"github.com/spf13/cobra"
+)
+
+// cacheEntry is the on-disk record for a single file's cached hash. It's
+// invalidated whenever the file's size or modification time no longer
+// match what was recorded.
+type cacheEntry struct {
+	Size        int64  `json:"size"`
+	ModUnixNano int64  `json:"mtime_unix_nano"`
+	HashAlgo    string `json:"hash_algo"`
+	HashHex     string `json:"hash_hex"`
+}
+
+// hashCache is a JSON-backed cache of full file hashes keyed by absolute
+// path, so re-running against the same source library doesn't re-hash
+// content that hasn't changed since the last run.
+type hashCache struct {
+	mu      sync.Mutex
+	path    string
+	entries map[string]cacheEntry
+	dirty   bool
+}
+
+var (
+	cachePath string
+	fileCache *hashCache
+)
+
+func init() {
+	defaultCachePath := filepath.Join(".cache", "deduplicate", "hashes.db")
+	if home, err := os.UserHomeDir(); err == nil {
+		defaultCachePath = filepath.Join(home, ".cache", "deduplicate", "hashes.db")
+	}
+	rootCmd.PersistentFlags().StringVar(&cachePath, "cache", defaultCachePath, "Path to the on-disk hash cache, empty to disable caching")
+
+	cacheCmd := &cobra.Command{
+		Use:   "cache",
+		Short: "Manage the on-disk hash cache",
+	}
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "prune",
+		Short: "Remove cache entries for files that no longer exist or have changed",
+		Run:   runCachePrune,
+	})
+	cacheCmd.AddCommand(&cobra.Command{
+		Use:   "clear",
+		Short: "Delete the entire hash cache",
+		Run:   runCacheClear,
+	})
+	rootCmd.AddCommand(cacheCmd)
+}
+
+func newHashCache(path string) *hashCache {
+	return &hashCache{path: path, entries: map[string]cacheEntry{}}
+}
+
+// loadCache reads the cache file at path, returning an empty cache if it
+// doesn't exist yet. An empty path disables caching: the returned cache
+// simply never persists anything.
+func loadCache(path string) (*hashCache, error) {
+	c := newHashCache(path)
+	if path == "" {
+		return c, nil
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return c, nil
+		}
+		return c, err
+	}
+	if err := json.Unmarshal(data, &c.entries); err != nil {
+		return c, err
+	}
+	return c, nil
+}
+
+// get returns the cached hash for path if its size and modification time
+// still match what was recorded for the requested algorithm.
+func (c *hashCache) get(path string, size int64, modUnixNano int64, algo string) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	entry, ok := c.entries[path]
+	if !ok || entry.Size != size || entry.ModUnixNano != modUnixNano || entry.HashAlgo != algo {
+		return "", false
+	}
+	return entry.HashHex, true
+}
+
+// put records path's computed hash, overwriting any stale entry.
+func (c *hashCache) put(path string, size int64, modUnixNano int64, algo, hashHex string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.entries[path] = cacheEntry{Size: size, ModUnixNano: modUnixNano, HashAlgo: algo, HashHex: hashHex}
+	c.dirty = true
+}
+
+// save writes the cache back to disk if it was modified and caching isn't
+// disabled.
+func (c *hashCache) save() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.path == "" || !c.dirty {
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(c.path), 0o755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(c.entries)
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(c.path, data, 0o644); err != nil {
+		return err
+	}
+	c.dirty = false
+	return nil
+}
+
+// prune drops entries for files that no longer exist or whose size or
+// modification time no longer match what's on disk, returning how many
+// entries were removed.
+func (c *hashCache) prune() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	removed := 0
+	for path, entry := range c.entries {
+		info, err := os.Stat(path)
+		if err != nil || info.Size() != entry.Size || info.ModTime().UnixNano() != entry.ModUnixNano {
+			delete(c.entries, path)
+			removed++
+		}
+	}
+	if removed > 0 {
+		c.dirty = true
+	}
+	return removed
+}
+
+func runCachePrune(cmd *cobra.Command, args []string) {
+	if cachePath == "" {
+		fmt.Println("Cache is disabled, nothing to prune")
+		return
+	}
+	cache, err := loadCache(cachePath)
+	if err != nil {
+		fmt.Println("Error loading cache:", err)
+		os.Exit(1)
+	}
+	removed := cache.prune()
+	if err := cache.save(); err != nil {
+		fmt.Println("Error saving cache:", err)
+		os.Exit(1)
+	}
+	fmt.Printf("Removed %d stale entries from %s\n", removed, cachePath)
+}
+
+func runCacheClear(cmd *cobra.Command, args []string) {
+	if cachePath == "" {
+		fmt.Println("Cache is disabled, nothing to clear")
+		return
+	}
+	if err := os.Remove(cachePath); err != nil && !os.IsNotExist(err) {
+		fmt.Println("Error clearing cache:", err)
+		os.Exit(1)
+	}
+	fmt.Println("Cleared cache at", cachePath)
+}
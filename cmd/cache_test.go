@@ -0,0 +1,70 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashCacheRoundTrip(t *testing.T) {
+	dbPath := filepath.Join(t.TempDir(), "hashes.db")
+
+	c, err := loadCache(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := c.get("/some/file.jpg", 10, 1, "sha512"); ok {
+		t.Fatal("expected a miss on an empty cache")
+	}
+
+	c.put("/some/file.jpg", 10, 1, "sha512", "deadbeef")
+	if err := c.save(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := loadCache(dbPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, ok := reloaded.get("/some/file.jpg", 10, 1, "sha512")
+	if !ok || hash != "deadbeef" {
+		t.Fatalf("expected a cache hit with hash deadbeef, got %q (hit=%v)", hash, ok)
+	}
+
+	if _, ok := reloaded.get("/some/file.jpg", 11, 1, "sha512"); ok {
+		t.Fatal("expected size change to invalidate the cache entry")
+	}
+	if _, ok := reloaded.get("/some/file.jpg", 10, 1, "blake3"); ok {
+		t.Fatal("expected algorithm change to invalidate the cache entry")
+	}
+}
+
+func TestHashCachePrune(t *testing.T) {
+	dir := t.TempDir()
+	kept := filepath.Join(dir, "kept.jpg")
+	mustWriteFile(t, kept, "kept")
+	changed := filepath.Join(dir, "changed.jpg")
+	mustWriteFile(t, changed, "changed")
+	missing := filepath.Join(dir, "gone.jpg")
+
+	keptInfo, err := os.Stat(kept)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changedInfo, err := os.Stat(changed)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newHashCache(filepath.Join(dir, "hashes.db"))
+	c.put(kept, keptInfo.Size(), keptInfo.ModTime().UnixNano(), "sha512", "keephash")
+	c.put(missing, 4, 1, "sha512", "gonehash")
+	c.put(changed, changedInfo.Size()+1, changedInfo.ModTime().UnixNano(), "sha512", "stalehash")
+
+	if removed := c.prune(); removed != 2 {
+		t.Fatalf("expected 2 stale entries pruned, got %d", removed)
+	}
+	if _, ok := c.get(kept, keptInfo.Size(), keptInfo.ModTime().UnixNano(), "sha512"); !ok {
+		t.Fatal("expected the still-valid entry to survive pruning")
+	}
+}
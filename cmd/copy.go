@@ -0,0 +1,176 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"sync"
+	"syscall"
+)
+
+var overwrite bool
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&overwrite, "overwrite", false, "Overwrite an existing, different destination file instead of refusing to write it")
+}
+
+// errSourceRemoveFailed wraps a failure to remove the source file after its
+// contents were already safely copied to the destination, so callers can
+// still tell a successful-but-dangling move apart from an outright failure.
+type errSourceRemoveFailed struct {
+	err error
+}
+
+func (e *errSourceRemoveFailed) Error() string { return e.err.Error() }
+func (e *errSourceRemoveFailed) Unwrap() error { return e.err }
+
+// checkDestination validates that dst may be written to from src. It
+// reports same=true when dst is already the same file as src (a no-op),
+// and refuses to replace an existing, different file unless --overwrite
+// is set.
+func checkDestination(src, dst string) (same bool, err error) {
+	sfi, err := os.Stat(src)
+	if err != nil {
+		return false, err
+	}
+	if !sfi.Mode().IsRegular() {
+		return false, fmt.Errorf("non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
+	}
+
+	dfi, err := os.Stat(dst)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return false, nil
+		}
+		return false, err
+	}
+	if !dfi.Mode().IsRegular() {
+		return false, fmt.Errorf("non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
+	}
+	if os.SameFile(sfi, dfi) {
+		return true, nil
+	}
+	if !overwrite {
+		return false, fmt.Errorf("destination %q already exists, use --overwrite to replace it", dst)
+	}
+	return false, nil
+}
+
+// copyFile copies src to dst. If dst is already the same file as src, it's
+// a no-op. Otherwise it first tries a hard link (cheap, and atomic in its
+// own right); if that's not possible it falls back to a full content copy.
+func copyFile(src, dst string) error {
+	same, err := checkDestination(src, dst)
+	if err != nil || same {
+		return err
+	}
+	if err := os.Link(src, dst); err == nil {
+		return nil
+	}
+	return copyFileContents(src, dst)
+}
+
+// moveFile moves src to dst. It tries an atomic rename first; if src and
+// dst are on different filesystems (EXDEV), it falls back to a crash-safe
+// copy and only unlinks src once that copy has been renamed into place.
+func moveFile(src, dst string) error {
+	same, err := checkDestination(src, dst)
+	if err != nil || same {
+		return err
+	}
+
+	if err := os.Rename(src, dst); err == nil {
+		return nil
+	} else if !errors.Is(err, syscall.EXDEV) {
+		return err
+	}
+
+	if err := copyFileContents(src, dst); err != nil {
+		return err
+	}
+	if err := os.Remove(src); err != nil {
+		return &errSourceRemoveFailed{err: err}
+	}
+	return nil
+}
+
+// copyFileContents copies the contents of src into dst. To avoid ever
+// leaving a half-written file at dst if the process is interrupted, it
+// streams into a temporary file in dst's directory, fsyncs it, and only
+// then renames it into place; on any error the temporary file is removed.
+func copyFileContents(src, dst string) (err error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.CreateTemp(filepath.Dir(dst), filepath.Base(dst)+".part-*")
+	if err != nil {
+		return err
+	}
+	tmpPath := out.Name()
+	_ = out.Chmod(0o644)
+	registerPartFile(tmpPath)
+	defer unregisterPartFile(tmpPath)
+	defer func() {
+		if err != nil {
+			out.Close()
+			os.Remove(tmpPath)
+		}
+	}()
+
+	if _, err = io.Copy(out, in); err != nil {
+		return err
+	}
+	if err = out.Sync(); err != nil {
+		return err
+	}
+	if err = out.Close(); err != nil {
+		return err
+	}
+	if err = os.Rename(tmpPath, dst); err != nil {
+		return err
+	}
+	return nil
+}
+
+var (
+	partFilesMutex sync.Mutex
+	partFiles      = map[string]bool{}
+)
+
+func registerPartFile(path string) {
+	partFilesMutex.Lock()
+	defer partFilesMutex.Unlock()
+	partFiles[path] = true
+}
+
+func unregisterPartFile(path string) {
+	partFilesMutex.Lock()
+	defer partFilesMutex.Unlock()
+	delete(partFiles, path)
+}
+
+func removePartFiles() {
+	partFilesMutex.Lock()
+	defer partFilesMutex.Unlock()
+	for path := range partFiles {
+		os.Remove(path)
+	}
+}
+
+// installPartFileCleanup arranges for any in-flight ".part-*" temp files to
+// be removed if the process is interrupted mid-copy.
+func installPartFileCleanup() {
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		removePartFiles()
+		os.Exit(1)
+	}()
+}
@@ -0,0 +1,97 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCopyFileContentsWritesExpectedData(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.jpg")
+	dst := filepath.Join(dir, "dst.jpg")
+	mustWriteFile(t, src, "hello world")
+
+	if err := copyFileContents(src, dst); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "hello world" {
+		t.Fatalf("expected %q, got %q", "hello world", got)
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, e := range entries {
+		if filepath.Ext(e.Name()) != ".jpg" {
+			t.Fatalf("expected no leftover temp files, found %q", e.Name())
+		}
+	}
+}
+
+func TestCopyFileRefusesOverwriteByDefault(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.jpg")
+	dst := filepath.Join(dir, "dst.jpg")
+	mustWriteFile(t, src, "new contents")
+	mustWriteFile(t, dst, "old contents")
+
+	overwrite = false
+	if err := copyFile(src, dst); err == nil {
+		t.Fatal("expected copyFile to refuse to overwrite an existing different file")
+	}
+
+	overwrite = true
+	defer func() { overwrite = false }()
+	if err := copyFile(src, dst); err != nil {
+		t.Fatalf("expected copyFile to succeed with --overwrite, got %v", err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "new contents" {
+		t.Fatalf("expected destination to be overwritten, got %q", got)
+	}
+}
+
+func TestMoveFileRenamesAndRemovesSource(t *testing.T) {
+	dir := t.TempDir()
+	src := filepath.Join(dir, "src.jpg")
+	dst := filepath.Join(dir, "dst.jpg")
+	mustWriteFile(t, src, "move me")
+
+	if err := moveFile(src, dst); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(src); !os.IsNotExist(err) {
+		t.Fatalf("expected source to be gone after move, stat err: %v", err)
+	}
+	got, err := os.ReadFile(dst)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != "move me" {
+		t.Fatalf("expected %q, got %q", "move me", got)
+	}
+}
+
+func TestCheckDestinationSameFileIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "a.jpg")
+	mustWriteFile(t, path, "a")
+
+	same, err := checkDestination(path, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !same {
+		t.Fatal("expected a file compared against itself to be reported as the same file")
+	}
+}
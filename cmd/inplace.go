@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"sync/atomic"
+)
+
+// duplicatePair records that duplicate was found to have the same content
+// as keeper, for --in-place to hard link together afterwards.
+type duplicatePair struct {
+	duplicate string
+	keeper    string
+}
+
+var (
+	inPlace    bool
+	bytesSaved int64
+
+	duplicatePairsMutex sync.Mutex
+	duplicatePairs      []duplicatePair
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVar(&inPlace, "in-place", false, "Deduplicate a single source in place by hard linking duplicates to their keeper instead of copying to --destination")
+}
+
+// processInPlace reclaims storage for every exact duplicate found during
+// populateHash by hard linking it to its keeper and removing the separate
+// copy, reporting the total bytes saved.
+func processInPlace() {
+	fmt.Println()
+	atomic.StoreInt64(&bytesSaved, 0)
+
+	wg := sync.WaitGroup{}
+	for _, pair := range duplicatePairs {
+		wg.Add(1)
+		p := pair
+		workerJobs <- func() {
+			defer wg.Done()
+			saved, err := linkDuplicate(p.duplicate, p.keeper)
+			if err != nil {
+				fmt.Printf("Error: could not reclaim '%s': %v\n", p.duplicate, err)
+				atomic.AddInt64(&copyErrors, 1)
+				return
+			}
+			atomic.AddInt64(&bytesSaved, saved)
+		}
+	}
+	wg.Wait()
+}
+
+// linkDuplicate replaces duplicatePath with a hard link to keeperPath,
+// returning the number of bytes reclaimed. The new link is put in place
+// with a rename so the keeper is always linked-to before the duplicate's
+// original inode is unlinked, never leaving duplicatePath missing.
+func linkDuplicate(duplicatePath, keeperPath string) (int64, error) {
+	dupInfo, err := os.Lstat(duplicatePath)
+	if err != nil {
+		return 0, err
+	}
+	keeperInfo, err := os.Stat(keeperPath)
+	if err != nil {
+		return 0, err
+	}
+	if os.SameFile(dupInfo, keeperInfo) {
+		// Already linked to the keeper; nothing left to reclaim.
+		return 0, nil
+	}
+	size := dupInfo.Size()
+
+	if simulate {
+		fmt.Printf("Would link '%s' to '%s'\n", duplicatePath, keeperPath)
+		return size, nil
+	}
+
+	tmpPath := duplicatePath + ".link-tmp"
+	_ = os.Remove(tmpPath)
+	if err := os.Link(keeperPath, tmpPath); err != nil {
+		fmt.Printf("Warning: could not hard link '%s' to '%s': %v, leaving duplicate untouched\n", duplicatePath, keeperPath, err)
+		return 0, nil
+	}
+	if err := os.Rename(tmpPath, duplicatePath); err != nil {
+		os.Remove(tmpPath)
+		return 0, err
+	}
+	fmt.Printf("Linked '%s' to '%s', reclaimed %d bytes\n", duplicatePath, keeperPath, size)
+	return size, nil
+}
@@ -0,0 +1,80 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+)
+
+func TestLinkDuplicateReclaimsSpace(t *testing.T) {
+	dir := t.TempDir()
+	keeper := filepath.Join(dir, "keeper.jpg")
+	duplicate := filepath.Join(dir, "duplicate.jpg")
+	mustWriteFile(t, keeper, "same contents")
+	mustWriteFile(t, duplicate, "same contents")
+
+	resetGlobalsForTest()
+	saved, err := linkDuplicate(duplicate, keeper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != int64(len("same contents")) {
+		t.Fatalf("expected %d bytes saved, got %d", len("same contents"), saved)
+	}
+
+	keeperInfo, err := os.Stat(keeper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dupInfo, err := os.Stat(duplicate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !os.SameFile(keeperInfo, dupInfo) {
+		t.Fatal("expected the duplicate to now be hard linked to the keeper")
+	}
+}
+
+func TestLinkDuplicateAlreadyLinkedIsNoop(t *testing.T) {
+	dir := t.TempDir()
+	keeper := filepath.Join(dir, "keeper.jpg")
+	duplicate := filepath.Join(dir, "duplicate.jpg")
+	mustWriteFile(t, keeper, "same contents")
+	if err := os.Link(keeper, duplicate); err != nil {
+		t.Fatal(err)
+	}
+
+	resetGlobalsForTest()
+	saved, err := linkDuplicate(duplicate, keeper)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if saved != 0 {
+		t.Fatalf("expected 0 bytes saved for an already-linked duplicate, got %d", saved)
+	}
+}
+
+func TestProcessInPlaceReclaimsAllDuplicates(t *testing.T) {
+	dir := t.TempDir()
+	keeper := filepath.Join(dir, "keeper.jpg")
+	dup1 := filepath.Join(dir, "dup1.jpg")
+	dup2 := filepath.Join(dir, "dup2.jpg")
+	mustWriteFile(t, keeper, "payload")
+	mustWriteFile(t, dup1, "payload")
+	mustWriteFile(t, dup2, "payload")
+
+	resetGlobalsForTest()
+	duplicatePairs = []duplicatePair{
+		{duplicate: dup1, keeper: keeper},
+		{duplicate: dup2, keeper: keeper},
+	}
+	stop := startTestWorkers()
+	defer stop()
+
+	processInPlace()
+
+	if got := atomic.LoadInt64(&bytesSaved); got != int64(2*len("payload")) {
+		t.Fatalf("expected %d bytes saved, got %d", 2*len("payload"), got)
+	}
+}
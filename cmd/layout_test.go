@@ -0,0 +1,102 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestParseLayoutDetectsCASPrefix(t *testing.T) {
+	tmpl, casMode, err := parseLayout("cas:{{slice .Hash 0 2}}/{{.Hash}}{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !casMode {
+		t.Fatal("expected the \"cas:\" prefix to enable CAS mode")
+	}
+	if tmpl == nil {
+		t.Fatal("expected a parsed template")
+	}
+}
+
+func TestParseLayoutWithoutCASPrefix(t *testing.T) {
+	_, casMode, err := parseLayout("{{.Year}}/{{.Month}}/{{.Day}} {{.Base}}{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if casMode {
+		t.Fatal("did not expect CAS mode without the \"cas:\" prefix")
+	}
+}
+
+func TestRenderLayoutDateTemplate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	mustWriteFile(t, path, "contents")
+	modTime := time.Date(2023, time.March, 5, 12, 0, 0, 0, time.UTC)
+	if err := os.Chtimes(path, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+
+	tmpl, _, err := parseLayout("{{.Year}}/{{.Month}}/{{.Day}} {{.Base}}{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := renderLayout(tmpl, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "2023/03/05 photo.jpg"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestRenderLayoutCASTemplate(t *testing.T) {
+	resetGlobalsForTest()
+	path := filepath.Join(t.TempDir(), "photo.jpg")
+	mustWriteFile(t, path, "contents")
+
+	tmpl, casMode, err := parseLayout("cas:{{slice .Hash 0 2}}/{{.Hash}}{{.Ext}}")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !casMode {
+		t.Fatal("expected CAS mode")
+	}
+
+	got, err := renderLayout(tmpl, path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hash, err := fullHash(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := hash[:2] + "/" + hash + ".jpg"
+	if got != want {
+		t.Fatalf("expected %q, got %q", want, got)
+	}
+}
+
+func TestPrepCASDirs(t *testing.T) {
+	dest := t.TempDir()
+	if err := prepCASDirs(dest); err != nil {
+		t.Fatal(err)
+	}
+	for _, shard := range []string{"00", "a1", "ff"} {
+		if info, err := os.Stat(filepath.Join(dest, shard)); err != nil || !info.IsDir() {
+			t.Fatalf("expected shard directory %q to exist", shard)
+		}
+	}
+}
+
+func TestExifCameraMissingReturnsEmpty(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "no-exif.jpg")
+	mustWriteFile(t, path, "not a real jpeg")
+
+	if camera := exifCamera(path); camera != "" {
+		t.Fatalf("expected no camera model for a file without EXIF, got %q", camera)
+	}
+}
@@ -0,0 +1,225 @@
+package cmd
+
+import (
+	"fmt"
+	"image"
+	_ "image/gif"
+	_ "image/jpeg"
+	_ "image/png"
+	"math/bits"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// imageExtensions is the subset of extensions perceptual clustering runs
+// on; video files are left entirely to the exact-hash path.
+var imageExtensions = map[string]interface{}{
+	".jpg":  nil,
+	".jpeg": nil,
+	".jpe":  nil,
+	".png":  nil,
+	".gif":  nil,
+}
+
+var (
+	perceptual     bool
+	phashThreshold int
+)
+
+func init() {
+	rootCmd.PersistentFlags().BoolVarP(&perceptual, "perceptual", "p", false, "Also cluster visually similar images and keep only the highest-resolution copy of each")
+	rootCmd.PersistentFlags().IntVar(&phashThreshold, "phash-threshold", 5, "Maximum Hamming distance between perceptual hashes to treat two images as near-duplicates")
+}
+
+// processPerceptual clusters near-duplicate images among the files that
+// survived exact-hash deduplication, keeping only the highest-resolution
+// file in each cluster and dropping the rest from hashes.
+func processPerceptual() {
+	type imageCandidate struct {
+		key  string
+		path string
+		hash uint64
+	}
+
+	candidatesByPath := map[string]imageCandidate{}
+	var candidates []imageCandidate
+	for key, path := range hashes {
+		if _, ok := imageExtensions[strings.ToLower(filepath.Ext(path))]; !ok {
+			continue
+		}
+		dHash, err := computeDHash(path)
+		if err != nil {
+			fmt.Println("Warning: could not compute perceptual hash for", path, err)
+			continue
+		}
+		c := imageCandidate{key: key, path: path, hash: dHash}
+		candidates = append(candidates, c)
+		candidatesByPath[path] = c
+	}
+	// Sort for deterministic clustering regardless of map iteration order.
+	sort.Slice(candidates, func(i, j int) bool { return candidates[i].path < candidates[j].path })
+
+	tree := &bkTree{}
+	clustered := map[string]bool{}
+
+	for _, c := range candidates {
+		if clustered[c.path] {
+			continue
+		}
+		matches := tree.query(c.hash, phashThreshold)
+		tree.insert(c.path, c.hash)
+
+		var unclustered []string
+		for _, m := range matches {
+			if !clustered[m] {
+				unclustered = append(unclustered, m)
+			}
+		}
+		if len(unclustered) == 0 {
+			continue
+		}
+
+		cluster := append(unclustered, c.path)
+		keeperPath := cluster[0]
+		keeperRes, _ := imageResolution(keeperPath)
+		for _, path := range cluster[1:] {
+			if res, err := imageResolution(path); err == nil && res > keeperRes {
+				keeperPath = path
+				keeperRes = res
+			}
+		}
+
+		for _, path := range cluster {
+			if path == keeperPath {
+				continue
+			}
+			clustered[path] = true
+			delete(hashes, candidatesByPath[path].key)
+			atomic.AddInt64(&collisions, 1)
+			fmt.Printf("Perceptual duplicate: '%s' is close to keeper '%s'. Ignoring it.\n", path, keeperPath)
+		}
+	}
+}
+
+// computeDHash produces a 64-bit difference hash: the image is resized to
+// 9x8 grayscale and each bit records whether a pixel is darker than its
+// right neighbor.
+func computeDHash(path string) (uint64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	img, _, err := image.Decode(f)
+	if err != nil {
+		return 0, err
+	}
+
+	const w, h = 9, 8
+	gray := resizeGray(img, w, h)
+
+	var hash uint64
+	for y := 0; y < h; y++ {
+		for x := 0; x < w-1; x++ {
+			hash <<= 1
+			if gray.GrayAt(x, y).Y < gray.GrayAt(x+1, y).Y {
+				hash |= 1
+			}
+		}
+	}
+	return hash, nil
+}
+
+// resizeGray nearest-neighbor resizes img to w x h and converts it to
+// grayscale; dHash only needs a coarse approximation of the image.
+func resizeGray(img image.Image, w, h int) *image.Gray {
+	bounds := img.Bounds()
+	srcW, srcH := bounds.Dx(), bounds.Dy()
+	out := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		sy := bounds.Min.Y + y*srcH/h
+		for x := 0; x < w; x++ {
+			sx := bounds.Min.X + x*srcW/w
+			out.Set(x, y, img.At(sx, sy))
+		}
+	}
+	return out
+}
+
+// imageResolution decodes just the header to get width*height, used to pick
+// the keeper of a perceptual cluster without reading the whole file.
+func imageResolution(path string) (int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	cfg, _, err := image.DecodeConfig(f)
+	if err != nil {
+		return 0, err
+	}
+	return int64(cfg.Width) * int64(cfg.Height), nil
+}
+
+// bkNode is a BK-tree node: children are keyed by their Hamming distance to
+// this node's hash.
+type bkNode struct {
+	path     string
+	hash     uint64
+	children map[int]*bkNode
+}
+
+// bkTree is a Burkhard-Keller tree over perceptual hashes, giving
+// sublinear near-duplicate lookups by the triangle inequality on Hamming
+// distance.
+type bkTree struct {
+	root *bkNode
+}
+
+func (t *bkTree) insert(path string, hash uint64) {
+	if t.root == nil {
+		t.root = &bkNode{path: path, hash: hash, children: map[int]*bkNode{}}
+		return
+	}
+	node := t.root
+	for {
+		d := hammingDistance(hash, node.hash)
+		child, ok := node.children[d]
+		if !ok {
+			node.children[d] = &bkNode{path: path, hash: hash, children: map[int]*bkNode{}}
+			return
+		}
+		node = child
+	}
+}
+
+// query returns every path within threshold Hamming distance of hash.
+func (t *bkTree) query(hash uint64, threshold int) []string {
+	if t.root == nil {
+		return nil
+	}
+	var matches []string
+	var visit func(node *bkNode)
+	visit = func(node *bkNode) {
+		d := hammingDistance(hash, node.hash)
+		if d <= threshold {
+			matches = append(matches, node.path)
+		}
+		for edge, child := range node.children {
+			if edge >= d-threshold && edge <= d+threshold {
+				visit(child)
+			}
+		}
+	}
+	visit(t.root)
+	return matches
+}
+
+func hammingDistance(a, b uint64) int {
+	return bits.OnesCount64(a ^ b)
+}
@@ -0,0 +1,150 @@
+package cmd
+
+import (
+	"image"
+	"image/color"
+	"image/png"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func writeTestPNG(t *testing.T, path string, w, h int, colorFunc func(x, y int) uint8) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	img := image.NewGray(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			img.SetGray(x, y, color.Gray{Y: colorFunc(x, y)})
+		}
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if err := png.Encode(f, img); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestHammingDistance(t *testing.T) {
+	if d := hammingDistance(0, 0); d != 0 {
+		t.Fatalf("expected 0, got %d", d)
+	}
+	if d := hammingDistance(0b1010, 0b0101); d != 4 {
+		t.Fatalf("expected 4, got %d", d)
+	}
+}
+
+func TestBKTreeQuery(t *testing.T) {
+	tree := &bkTree{}
+	tree.insert("a", 0b0000)
+	tree.insert("b", 0b0001)
+	tree.insert("c", 0b1111)
+
+	matches := tree.query(0b0000, 1)
+	got := map[string]bool{}
+	for _, m := range matches {
+		got[m] = true
+	}
+	if !got["a"] || !got["b"] || got["c"] {
+		t.Fatalf("unexpected matches: %v", matches)
+	}
+}
+
+func TestComputeDHashIdenticalImagesMatch(t *testing.T) {
+	dir := t.TempDir()
+	horizontalGradient := func(x, y int) uint8 { return uint8(x * 16) }
+
+	pathA := filepath.Join(dir, "a.png")
+	pathB := filepath.Join(dir, "b.png")
+	writeTestPNG(t, pathA, 32, 32, horizontalGradient)
+	writeTestPNG(t, pathB, 32, 32, horizontalGradient)
+
+	hashA, err := computeDHash(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := computeDHash(pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hashA != hashB {
+		t.Fatalf("expected identical images to produce the same dHash, got %064b vs %064b", hashA, hashB)
+	}
+}
+
+func TestComputeDHashDissimilarImagesDiffer(t *testing.T) {
+	dir := t.TempDir()
+	horizontal := func(x, y int) uint8 { return uint8(x * 16) }
+	vertical := func(x, y int) uint8 { return uint8(255 - y*16) }
+
+	pathA := filepath.Join(dir, "a.png")
+	pathB := filepath.Join(dir, "b.png")
+	writeTestPNG(t, pathA, 32, 32, horizontal)
+	writeTestPNG(t, pathB, 32, 32, vertical)
+
+	hashA, err := computeDHash(pathA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashB, err := computeDHash(pathB)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hammingDistance(hashA, hashB) <= phashThreshold {
+		t.Fatalf("expected dissimilar images to exceed the threshold, got distance %d", hammingDistance(hashA, hashB))
+	}
+}
+
+func TestImageResolution(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "a.png")
+	writeTestPNG(t, path, 20, 10, func(x, y int) uint8 { return 0 })
+
+	res, err := imageResolution(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res != 200 {
+		t.Fatalf("expected resolution 200, got %d", res)
+	}
+}
+
+func TestProcessPerceptualKeepsHighestResolution(t *testing.T) {
+	dir := t.TempDir()
+	gradient := func(x, y int) uint8 { return uint8(x * 4) }
+
+	small := filepath.Join(dir, "small.png")
+	large := filepath.Join(dir, "large.png")
+	writeTestPNG(t, small, 32, 32, gradient)
+	writeTestPNG(t, large, 64, 64, gradient)
+
+	resetGlobalsForTest()
+	phashThreshold = 5
+	hashes = map[string]string{
+		"key-small": small,
+		"key-large": large,
+	}
+	collisions = 0
+
+	processPerceptual()
+
+	if len(hashes) != 1 {
+		t.Fatalf("expected the cluster to collapse to 1 file, got %d: %v", len(hashes), hashes)
+	}
+	found := false
+	for _, path := range hashes {
+		if path == large {
+			found = true
+		}
+	}
+	if !found {
+		t.Fatalf("expected the higher-resolution file to be kept, got %v", hashes)
+	}
+	if collisions != 1 {
+		t.Fatalf("expected 1 perceptual duplicate counted, got %d", collisions)
+	}
+}
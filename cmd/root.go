@@ -1,30 +1,48 @@
 package cmd
 
 import (
+	"crypto/md5"
+	"crypto/sha256"
 	"crypto/sha512"
 	"errors"
 	"fmt"
+	"hash"
 	"io"
-	"io/ioutil"
 	"os"
+	"path"
 	"path/filepath"
 	"runtime"
 	"strings"
 	"sync"
 	"sync/atomic"
+	"text/template"
 	"time"
 
 	"github.com/spf13/cobra"
 
 	"github.com/rwcarlsen/goexif/exif"
+	"github.com/zeebo/xxh3"
 
 	"gopkg.in/djherbis/times.v1"
+	"lukechampine.com/blake3"
 )
 
 const timeFormat = "20060102T150405"
 
+// hashPrefixSize is how much of a file's head is hashed for the cheap
+// pre-filter stage, before any full-file hash is computed.
+const hashPrefixSize = 64 * 1024
+
 type (
 	workerJob func()
+
+	// prefixKey groups candidate files that might be identical: files
+	// with a different size or a different first-64KiB digest can never
+	// collide, so they're never worth a full hash.
+	prefixKey struct {
+		size   int64
+		prefix [16]byte
+	}
 )
 
 var (
@@ -53,16 +71,23 @@ var (
 	}
 	hashesMutex  = sync.Mutex{}
 	hashes       map[string]string
+	prefixGroups map[prefixKey][]string
+	hashedPaths  map[string]bool
 	collisions   int64
 	copyErrors   int64
 	removeErrors int64
 
-	sources     []string
-	destination string
-	rename      bool
-	move        bool
-	simulate    bool
-	rootCmd     = &cobra.Command{
+	sources         []string
+	destination     string
+	rename          bool
+	move            bool
+	simulate        bool
+	includePatterns []string
+	excludePatterns []string
+	followSymlinks  bool
+	maxDepth        int
+	hashAlgo        string
+	rootCmd         = &cobra.Command{
 		Use:   "deduplicate",
 		Short: "Deduplicate allows to remove duplicate images",
 		Long:  `Deduplicate is an utility to remove duplicate images and rename the unique ones`,
@@ -78,9 +103,15 @@ func init() {
 	rootCmd.PersistentFlags().BoolVarP(&rename, "rename", "r", false, "Rename with file datetime prefix")
 	rootCmd.PersistentFlags().BoolVarP(&move, "move", "m", false, "Move files instead of copying")
 	rootCmd.PersistentFlags().BoolVarP(&simulate, "simulate", "l", false, "Simulate process")
+	rootCmd.PersistentFlags().StringArrayVar(&includePatterns, "include", nil, "Glob pattern a file's path (relative to its source) must match to be considered, repeatable")
+	rootCmd.PersistentFlags().StringArrayVar(&excludePatterns, "exclude", nil, "Glob pattern to skip, applied to files and directories relative to their source, repeatable")
+	rootCmd.PersistentFlags().BoolVar(&followSymlinks, "follow-symlinks", false, "Follow symlinked files and directories while scanning sources")
+	rootCmd.PersistentFlags().IntVar(&maxDepth, "max-depth", -1, "Maximum number of subdirectory levels to descend into each source (-1 for unlimited)")
+	rootCmd.PersistentFlags().StringVar(&hashAlgo, "hash", "sha512", "Hash algorithm used to compare file contents: sha512, sha256, blake3 or xxh3")
 }
 
 func Execute() {
+	installPartFileCleanup()
 	if err := rootCmd.Execute(); err != nil {
 		fmt.Println(err)
 		os.Exit(1)
@@ -105,11 +136,28 @@ func run(cmd *cobra.Command, args []string) {
 		fmt.Println("Error! sources shouldn't be empty")
 		os.Exit(1)
 	}
-	if dest == "" {
+	if inPlace {
+		if dest != "" {
+			fmt.Println("Error! --in-place cannot be combined with --destination")
+			os.Exit(1)
+		}
+	} else if dest == "" {
 		fmt.Println("Error! destination shouldn't be empty")
 		os.Exit(1)
 	}
 
+	cache, err := loadCache(cachePath)
+	if err != nil {
+		fmt.Println("Warning: could not load hash cache:", err)
+		cache = newHashCache(cachePath)
+	}
+	fileCache = cache
+	defer func() {
+		if err := fileCache.save(); err != nil {
+			fmt.Println("Warning: could not save hash cache:", err)
+		}
+	}()
+
 	// start workers
 	concurrency := runtime.NumCPU() * 2
 	workerJobs = make(chan workerJob, concurrency)
@@ -121,17 +169,43 @@ func run(cmd *cobra.Command, args []string) {
 	atomic.StoreInt64(&removeErrors, 0)
 
 	fmt.Println("Source Folders:", folders)
-	fmt.Println("Destination:", dest)
+	if inPlace {
+		fmt.Println("Mode: in-place (hard link duplicates to reclaim storage)")
+	} else {
+		fmt.Println("Destination:", dest)
+	}
 	fmt.Println("Rename:", rename)
 	fmt.Println("Move:", move)
+	fmt.Println("Overwrite:", overwrite)
+	fmt.Println("Layout:", layout)
+	fmt.Println("Include:", includePatterns)
+	fmt.Println("Exclude:", excludePatterns)
+	fmt.Println("Follow Symlinks:", followSymlinks)
+	fmt.Println("Max Depth:", maxDepth)
+	fmt.Println("Hash Algorithm:", hashAlgo)
+	fmt.Println("Cache:", cachePath)
+	fmt.Println("Perceptual:", perceptual)
+	if perceptual {
+		fmt.Println("Perceptual Hash Threshold:", phashThreshold)
+	}
 	fmt.Println("Concurrency Level:", concurrency)
 	fmt.Println()
 
 	fmt.Println("Calculating hashes...")
 	populateHash(folders)
 	fmt.Println()
-	fmt.Println("Processing hashes...")
-	processHashes(dest)
+	if perceptual {
+		fmt.Println("Clustering perceptual duplicates...")
+		processPerceptual()
+		fmt.Println()
+	}
+	if inPlace {
+		fmt.Println("Reclaiming duplicate storage in place...")
+		processInPlace()
+	} else {
+		fmt.Println("Processing hashes...")
+		processHashes(dest)
+	}
 
 	close(workerJobs)
 
@@ -140,7 +214,9 @@ func run(cmd *cobra.Command, args []string) {
 	fmt.Println("Total number of images with no duplicates:", len(hashes))
 	fmt.Println("Total number of duplicates:", atomic.LoadInt64(&collisions))
 	fmt.Println("Total number of copy errors:", atomic.LoadInt64(&copyErrors))
-	if move {
+	if inPlace {
+		fmt.Println("Total bytes saved:", atomic.LoadInt64(&bytesSaved))
+	} else if move {
 		fmt.Println("Total number of remove errors:", atomic.LoadInt64(&removeErrors))
 	}
 	fmt.Printf("Done in %v", time.Since(start))
@@ -148,57 +224,324 @@ func run(cmd *cobra.Command, args []string) {
 
 func populateHash(folders []string) {
 	hashes = map[string]string{}
+	prefixGroups = map[prefixKey][]string{}
+	hashedPaths = map[string]bool{}
+	duplicatePairs = nil
 	wg := sync.WaitGroup{}
 	for _, item := range folders {
-		files, err := ioutil.ReadDir(item)
+		walkSource(item, &wg)
+	}
+
+	wg.Wait()
+
+	// Any (size, prefix) group that never grew past one member has no
+	// candidate to collide with, so it's provably unique content: skip the
+	// full hash entirely and register it under a key derived from the
+	// prefix group instead.
+	for key, group := range prefixGroups {
+		if len(group) == 1 && !hashedPaths[group[0]] {
+			hashes[fmt.Sprintf("size:%d/prefix:%x", key.size, key.prefix)] = group[0]
+		}
+	}
+
+	fmt.Println()
+	fmt.Println("Total number of images:", len(hashes))
+	fmt.Println("Total number of duplicates:", collisions)
+}
+
+// walkSource recursively scans root, feeding every file that survives the
+// include/exclude filters and the extension whitelist into workerJobs. It
+// optionally follows symlinked directories and files when followSymlinks is
+// set, guarding against cycles with visited.
+func walkSource(root string, wg *sync.WaitGroup) {
+	walkFrom(root, root, "", 0, map[string]bool{}, wg)
+}
+
+func walkFrom(walkRoot, originalRoot, relBase string, baseDepth int, visited map[string]bool, wg *sync.WaitGroup) {
+	walkFn := func(path string, d os.DirEntry, err error) error {
 		if err != nil {
-			fmt.Println("Error:", err)
+			fmt.Println("Error:", path, err)
+			return nil
+		}
+		if path == walkRoot {
+			return nil
 		}
-		for _, f := range files {
-			if f.IsDir() {
-				continue
+
+		rel := relBase
+		depth := baseDepth
+		if sub, relErr := filepath.Rel(walkRoot, path); relErr == nil {
+			sub = filepath.ToSlash(sub)
+			rel = filepath.ToSlash(filepath.Join(relBase, sub))
+			depth = baseDepth + strings.Count(sub, "/") + 1
+		}
+
+		if d.IsDir() {
+			if maxDepth >= 0 && depth > maxDepth {
+				return filepath.SkipDir
+			}
+			if matchesAny(excludePatterns, rel) {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+
+		if d.Type()&os.ModeSymlink != 0 {
+			if !followSymlinks {
+				return nil
+			}
+			target, evalErr := filepath.EvalSymlinks(path)
+			if evalErr != nil || visited[target] {
+				return nil
 			}
-			if _, ok := extensions[strings.ToLower(filepath.Ext(f.Name()))]; ok {
-				wg.Add(1)
-				fPath := item
-				fName := f.Name()
-				job := func() {
-					processFileHash(&wg, fPath, fName, &collisions)
+			info, statErr := os.Stat(target)
+			if statErr != nil {
+				return nil
+			}
+			if info.IsDir() {
+				if (maxDepth >= 0 && depth > maxDepth) || matchesAny(excludePatterns, rel) {
+					return nil
 				}
-				workerJobs <- job
+				visited[target] = true
+				walkFrom(target, originalRoot, rel, depth, visited, wg)
+			} else {
+				considerFile(target, rel, wg)
 			}
+			return nil
 		}
+
+		considerFile(path, rel, wg)
+		return nil
 	}
 
-	wg.Wait()
-	fmt.Println()
-	fmt.Println("Total number of images:", len(hashes))
-	fmt.Println("Total number of duplicates:", collisions)
+	if err := filepath.WalkDir(walkRoot, walkFn); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// considerFile enqueues path for hashing once it has survived the
+// include/exclude glob filters and the extension whitelist. rel is the
+// file's path relative to its source root, always using "/" separators so
+// patterns behave the same on every platform.
+func considerFile(path, rel string, wg *sync.WaitGroup) {
+	if matchesAny(excludePatterns, rel) {
+		return
+	}
+	if len(includePatterns) > 0 && !matchesAny(includePatterns, rel) {
+		return
+	}
+	if _, ok := extensions[strings.ToLower(filepath.Ext(path))]; !ok {
+		return
+	}
+
+	wg.Add(1)
+	fPath := filepath.Dir(path)
+	fName := filepath.Base(path)
+	job := func() {
+		processFileHash(wg, fPath, fName, &collisions)
+	}
+	workerJobs <- job
+}
+
+// matchesAny reports whether rel matches any of the given glob patterns.
+func matchesAny(patterns []string, rel string) bool {
+	for _, pattern := range patterns {
+		if matchGlob(pattern, rel) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchGlob reports whether rel, a "/"-separated path relative to a source
+// root, matches pattern. In addition to plain filepath.Match syntax (e.g.
+// "*.tmp"), pattern may use "**" to match zero or more whole path segments
+// (e.g. "**/thumbs/*").
+func matchGlob(pattern, rel string) bool {
+	if !strings.Contains(pattern, "**") {
+		ok, err := path.Match(pattern, rel)
+		return err == nil && ok
+	}
+	return matchGlobParts(strings.Split(pattern, "/"), strings.Split(rel, "/"))
+}
+
+func matchGlobParts(pattern, rel []string) bool {
+	if len(pattern) == 0 {
+		return len(rel) == 0
+	}
+	if pattern[0] == "**" {
+		if matchGlobParts(pattern[1:], rel) {
+			return true
+		}
+		if len(rel) == 0 {
+			return false
+		}
+		return matchGlobParts(pattern, rel[1:])
+	}
+	if len(rel) == 0 {
+		return false
+	}
+	if ok, err := path.Match(pattern[0], rel[0]); err != nil || !ok {
+		return false
+	}
+	return matchGlobParts(pattern[1:], rel[1:])
 }
 
 func processFileHash(wGroup *sync.WaitGroup, path string, fName string, collisionsCounter *int64) {
 	defer wGroup.Done()
 	fPath := filepath.Join(path, fName)
 
-	data, err := ioutil.ReadFile(fPath)
+	info, err := os.Stat(fPath)
+	if err != nil {
+		fmt.Println("Error:", fPath, err)
+		return
+	}
+	prefix, err := hashPrefix(fPath)
 	if err != nil {
 		fmt.Println("Error:", fPath, err)
+		return
+	}
+	key := prefixKey{size: info.Size(), prefix: prefix}
+
+	hashesMutex.Lock()
+	existing := prefixGroups[key]
+	prefixGroups[key] = append(existing, fPath)
+	hashesMutex.Unlock()
+
+	if len(existing) == 0 {
+		// First file seen with this size and prefix; nothing to compare
+		// against yet, so skip the expensive full hash until a real
+		// candidate collision shows up.
+		return
+	}
+
+	for _, candidate := range append(existing, fPath) {
+		registerFullHash(candidate, collisionsCounter)
+	}
+}
+
+// hashPrefix returns the MD5 digest of the first hashPrefixSize bytes of
+// path. It's only ever used as a coarse pre-filter key, never as the final
+// duplicate hash.
+func hashPrefix(path string) ([16]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [16]byte{}, err
+	}
+	defer f.Close()
+
+	h := md5.New()
+	if _, err := io.CopyN(h, f, hashPrefixSize); err != nil && err != io.EOF {
+		return [16]byte{}, err
+	}
+	var sum [16]byte
+	copy(sum[:], h.Sum(nil))
+	return sum, nil
+}
+
+// registerFullHash computes path's full hash at most once and records the
+// result in hashes, flagging anything that collides as a duplicate.
+func registerFullHash(path string, collisionsCounter *int64) {
+	hashesMutex.Lock()
+	if hashedPaths[path] {
+		hashesMutex.Unlock()
+		return
+	}
+	hashedPaths[path] = true
+	hashesMutex.Unlock()
+
+	strHash, err := fullHash(path)
+	if err != nil {
+		fmt.Println("Error:", path, err)
+		return
 	}
-	hash := sha512.Sum512(data)
-	strHash := fmt.Sprintf("%x", hash)
 
 	hashesMutex.Lock()
 	defer hashesMutex.Unlock()
 	if current, ok := hashes[strHash]; ok {
 		currentCollisions := atomic.AddInt64(collisionsCounter, 1)
-		fmt.Printf("(%d) File '%s' duplicate with: '%s'. Ignoring it. \n", currentCollisions, fPath, current)
+		fmt.Printf("(%d) File '%s' duplicate with: '%s'. Ignoring it. \n", currentCollisions, path, current)
+		duplicatePairsMutex.Lock()
+		duplicatePairs = append(duplicatePairs, duplicatePair{duplicate: path, keeper: current})
+		duplicatePairsMutex.Unlock()
 	} else {
-		hashes[strHash] = fPath
+		hashes[strHash] = path
+	}
+}
+
+// fullHash streams path's contents through the configured hash algorithm
+// rather than reading the whole file into memory, so multi-gigabyte media
+// files don't blow up peak RSS. It consults fileCache first and writes the
+// result back, keyed by size and modification time, so an unchanged file
+// never needs to be re-hashed on a later run.
+func fullHash(path string) (string, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	modUnixNano := info.ModTime().UnixNano()
+
+	if fileCache != nil {
+		if cached, ok := fileCache.get(path, info.Size(), modUnixNano, hashAlgo); ok {
+			return cached, nil
+		}
+	}
+
+	f, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h, err := newHasher(hashAlgo)
+	if err != nil {
+		return "", err
+	}
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	strHash := fmt.Sprintf("%x", h.Sum(nil))
+
+	if fileCache != nil {
+		fileCache.put(path, info.Size(), modUnixNano, hashAlgo, strHash)
+	}
+	return strHash, nil
+}
+
+// newHasher builds the hash.Hash for the algorithm named by --hash.
+func newHasher(algo string) (hash.Hash, error) {
+	switch strings.ToLower(algo) {
+	case "sha512":
+		return sha512.New(), nil
+	case "sha256":
+		return sha256.New(), nil
+	case "blake3":
+		return blake3.New(64, nil), nil
+	case "xxh3":
+		return xxh3.New(), nil
+	default:
+		return nil, fmt.Errorf("unsupported hash algorithm %q (expected sha512, sha256, blake3 or xxh3)", algo)
 	}
 }
 
 func processHashes(dest string) {
 	fmt.Println()
+
+	var layoutTmpl *template.Template
+	if layout != "" {
+		tmpl, casMode, err := parseLayout(layout)
+		if err != nil {
+			fmt.Println("Error: invalid --layout template:", err)
+			os.Exit(1)
+		}
+		if casMode {
+			if err := prepCASDirs(dest); err != nil {
+				fmt.Println("Error: could not prepare CAS directories:", err)
+				os.Exit(1)
+			}
+		}
+		layoutTmpl = tmpl
+	}
+
 	wg := sync.WaitGroup{}
 
 	for _, v := range hashes {
@@ -208,7 +551,16 @@ func processHashes(dest string) {
 			defer wg.Done()
 			var destFileName string
 
-			if rename {
+			switch {
+			case layoutTmpl != nil:
+				rendered, err := renderLayout(layoutTmpl, sourceFile)
+				if err != nil {
+					fmt.Println("Error: rendering --layout for", sourceFile, err)
+					atomic.AddInt64(&copyErrors, 1)
+					return
+				}
+				destFileName = filepath.FromSlash(rendered)
+			case rename:
 				fTime, err, noRename := getFileTime(sourceFile)
 				if err != nil {
 					fTime = time.Now()
@@ -220,7 +572,7 @@ func processHashes(dest string) {
 					destFileName = fmt.Sprintf("%s %s", fTime.Format(timeFormat), filepath.Base(sourceFile))
 					destFileName = strings.ReplaceAll(destFileName, ":", "")
 				}
-			} else {
+			default:
 				destFileName = filepath.Base(sourceFile)
 			}
 
@@ -232,19 +584,31 @@ func processHashes(dest string) {
 				if simulate {
 					fmt.Println(sourceFile, "->", destinationFile)
 				} else {
-					if !move {
-						fmt.Printf("Copying '%s' to '%s'\n", sourceFile, destinationFile)
-					} else {
+					if err := os.MkdirAll(filepath.Dir(destinationFile), 0o755); err != nil {
+						fmt.Printf("Error: creating destination directory for '%s': %v\n", destinationFile, err)
+						atomic.AddInt64(&copyErrors, 1)
+						return
+					}
+					var opErr error
+					if move {
 						fmt.Printf("Moving '%s' to '%s'\n", sourceFile, destinationFile)
+						opErr = moveFile(sourceFile, destinationFile)
+					} else {
+						fmt.Printf("Copying '%s' to '%s'\n", sourceFile, destinationFile)
+						opErr = copyFile(sourceFile, destinationFile)
 					}
-					err := copyFile(sourceFile, destinationFile)
-					if err != nil {
-						fmt.Printf("Error: copying file '%s': %v\n", sourceFile, err)
-						atomic.AddInt64(&copyErrors, 1)
-					} else if move {
-						if err := os.Remove(sourceFile); err != nil {
-							fmt.Printf("Error: removing source file '%s': %v", sourceFile, err)
+					if opErr != nil {
+						var removeErr *errSourceRemoveFailed
+						if errors.As(opErr, &removeErr) {
+							fmt.Printf("Error: removing source file '%s': %v\n", sourceFile, removeErr.err)
 							atomic.AddInt64(&removeErrors, 1)
+						} else {
+							verb := "copying"
+							if move {
+								verb = "moving"
+							}
+							fmt.Printf("Error: %s file '%s': %v\n", verb, sourceFile, opErr)
+							atomic.AddInt64(&copyErrors, 1)
 						}
 					}
 				}
@@ -289,66 +653,6 @@ func getFileTime(filePath string) (fileTime time.Time, err error, timeInPath boo
 	}
 }
 
-// CopyFile copies a file from src to dst. If src and dst files exist, and are
-// the same, then return success. Otherwise, attempt to create a hard link
-// between the two files. If that fail, copy the file contents from src to dst.
-func copyFile(src, dst string) (err error) {
-	sfi, err := os.Stat(src)
-	if err != nil {
-		return
-	}
-	if !sfi.Mode().IsRegular() {
-		// cannot copy non-regular files (e.g., directories,
-		// symlinks, devices, etc.)
-		return fmt.Errorf("CopyFile: non-regular source file %s (%q)", sfi.Name(), sfi.Mode().String())
-	}
-	dfi, err := os.Stat(dst)
-	if err != nil {
-		if !os.IsNotExist(err) {
-			return
-		}
-	} else {
-		if !(dfi.Mode().IsRegular()) {
-			return fmt.Errorf("CopyFile: non-regular destination file %s (%q)", dfi.Name(), dfi.Mode().String())
-		}
-		if os.SameFile(sfi, dfi) {
-			return
-		}
-	}
-	if err = os.Link(src, dst); err == nil {
-		return
-	}
-	err = copyFileContents(src, dst)
-	return
-}
-
-// copyFileContents copies the contents of the file named src to the file named
-// by dst. The file will be created if it does not already exist. If the
-// destination file exists, all it's contents will be replaced by the contents
-// of the source file.
-func copyFileContents(src, dst string) (err error) {
-	in, err := os.Open(src)
-	if err != nil {
-		return
-	}
-	defer in.Close()
-	out, err := os.Create(dst)
-	if err != nil {
-		return
-	}
-	defer func() {
-		cerr := out.Close()
-		if err == nil {
-			err = cerr
-		}
-	}()
-	if _, err = io.Copy(out, in); err != nil {
-		return
-	}
-	err = out.Sync()
-	return
-}
-
 func worker(id int) {
 	for {
 		select {
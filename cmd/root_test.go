@@ -0,0 +1,171 @@
+package cmd
+
+import (
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+)
+
+func TestMatchGlob(t *testing.T) {
+	cases := []struct {
+		pattern string
+		rel     string
+		want    bool
+	}{
+		{"*.tmp", "file.tmp", true},
+		{"*.tmp", "sub/file.tmp", false},
+		{"**/*.tmp", "sub/file.tmp", true},
+		{"**/thumbs/*", "a/b/thumbs/img.jpg", true},
+		{"**/thumbs/*", "thumbs/img.jpg", true},
+		{"photos/*", "photos/a.jpg", true},
+		{"photos/*", "photos/sub/a.jpg", false},
+	}
+	for _, c := range cases {
+		if got := matchGlob(c.pattern, c.rel); got != c.want {
+			t.Errorf("matchGlob(%q, %q) = %v, want %v", c.pattern, c.rel, got, c.want)
+		}
+	}
+}
+
+func TestPopulateHashRecursiveWithFilters(t *testing.T) {
+	root := t.TempDir()
+	mustWriteFile(t, filepath.Join(root, "a.jpg"), "a")
+	mustWriteFile(t, filepath.Join(root, "sub", "b.jpg"), "b")
+	mustWriteFile(t, filepath.Join(root, "sub", "deep", "c.jpg"), "c")
+	mustWriteFile(t, filepath.Join(root, "thumbs", "skip.jpg"), "skip")
+	mustWriteFile(t, filepath.Join(root, "ignore.tmp"), "ignore")
+
+	resetGlobalsForTest()
+	excludePatterns = []string{"**/thumbs/*", "*.tmp"}
+	stop := startTestWorkers()
+	defer stop()
+
+	populateHash([]string{root})
+
+	if len(hashes) != 3 {
+		t.Fatalf("expected 3 hashed files, got %d: %v", len(hashes), hashes)
+	}
+}
+
+func TestPopulateHashMaxDepth(t *testing.T) {
+	root := t.TempDir()
+	mustWriteFile(t, filepath.Join(root, "a.jpg"), "a")
+	mustWriteFile(t, filepath.Join(root, "sub", "b.jpg"), "b")
+	mustWriteFile(t, filepath.Join(root, "sub", "deep", "c.jpg"), "c")
+
+	resetGlobalsForTest()
+	maxDepth = 1
+	stop := startTestWorkers()
+	defer stop()
+
+	populateHash([]string{root})
+
+	if len(hashes) != 2 {
+		t.Fatalf("expected 2 hashed files within max-depth 1, got %d: %v", len(hashes), hashes)
+	}
+}
+
+func TestPopulateHashFollowSymlinks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("creating symlinks requires elevated privileges on windows")
+	}
+	root := t.TempDir()
+	target := t.TempDir()
+	mustWriteFile(t, filepath.Join(target, "linked.jpg"), "linked")
+	if err := os.Symlink(target, filepath.Join(root, "link")); err != nil {
+		t.Fatal(err)
+	}
+
+	resetGlobalsForTest()
+	followSymlinks = true
+	stop := startTestWorkers()
+	defer stop()
+
+	populateHash([]string{root})
+
+	if len(hashes) != 1 {
+		t.Fatalf("expected 1 hashed file via symlink, got %d: %v", len(hashes), hashes)
+	}
+}
+
+func resetGlobalsForTest() {
+	includePatterns = nil
+	excludePatterns = nil
+	followSymlinks = false
+	maxDepth = -1
+	hashAlgo = "sha512"
+	collisions = 0
+}
+
+func TestNewHasherUnknownAlgo(t *testing.T) {
+	if _, err := newHasher("md5"); err == nil {
+		t.Fatal("expected an error for an unsupported hash algorithm")
+	}
+}
+
+func TestPopulateHashDeduplicatesAcrossSizePrefixGroups(t *testing.T) {
+	root := t.TempDir()
+	mustWriteFile(t, filepath.Join(root, "a.jpg"), "same contents")
+	mustWriteFile(t, filepath.Join(root, "b.jpg"), "same contents")
+	mustWriteFile(t, filepath.Join(root, "c.jpg"), "different contents")
+
+	resetGlobalsForTest()
+	stop := startTestWorkers()
+	defer stop()
+
+	populateHash([]string{root})
+
+	if len(hashes) != 2 {
+		t.Fatalf("expected 2 unique files, got %d: %v", len(hashes), hashes)
+	}
+	if collisions != 1 {
+		t.Fatalf("expected 1 duplicate, got %d", collisions)
+	}
+}
+
+func TestPopulateHashSkipsFullHashForUniqueSizePrefix(t *testing.T) {
+	root := t.TempDir()
+	mustWriteFile(t, filepath.Join(root, "a.jpg"), "alpha")
+	mustWriteFile(t, filepath.Join(root, "b.jpg"), "bravo")
+
+	resetGlobalsForTest()
+	stop := startTestWorkers()
+	defer stop()
+
+	populateHash([]string{root})
+
+	if len(hashedPaths) != 0 {
+		t.Fatalf("expected no full hashes to be computed for singleton size/prefix groups, got %v", hashedPaths)
+	}
+	if len(hashes) != 2 {
+		t.Fatalf("expected both unique files to still be registered, got %d: %v", len(hashes), hashes)
+	}
+}
+
+// startTestWorkers spins up a single worker draining workerJobs, returning a
+// func that closes the channel and waits for the worker to drain it.
+func startTestWorkers() func() {
+	workerJobs = make(chan workerJob, 16)
+	done := make(chan struct{})
+	go func() {
+		for job := range workerJobs {
+			job()
+		}
+		close(done)
+	}()
+	return func() {
+		close(workerJobs)
+		<-done
+	}
+}
+
+func mustWriteFile(t *testing.T, path, contents string) {
+	t.Helper()
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatal(err)
+	}
+}